@@ -0,0 +1,197 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// asyncCompletions maps "<api>.<fn>" (api and C function name, the same
+// keys goFnName switches on) to the name of the EventType that signals
+// completion of that blocking call. A function only gets a <Name>Ctx
+// variant if it has an entry here -- extend this table, not the plugin, to
+// make another blocking call cancellable.
+var asyncCompletions = map[string]string{
+	"link.flow":        "LinkFlow",
+	"link.open":        "LinkLocalOpen",
+	"link.close":       "LinkLocalClose",
+	"session.open":     "SessionLocalOpen",
+	"session.close":    "SessionLocalClose",
+	"connection.open":  "ConnectionLocalOpen",
+	"connection.close": "ConnectionLocalClose",
+	"delivery.settle":  "Delivery",
+}
+
+func init() {
+	RegisterPlugin(asyncPlugin{})
+	RegisterPlugin(asyncTestPlugin{})
+}
+
+// asyncPlugin emits wrappers_async_gen.go: for every function named in
+// asyncCompletions, a <Name>Ctx(ctx, ...) variant of the existing blocking
+// wrapper. Proton engine objects aren't thread-safe, so the variant never
+// touches C from the caller's goroutine: it hands the call to
+// internal.Inject, which runs it on the event-loop goroutine that already
+// owns the handle, and only waits -- via internal.AwaitEvent -- on the
+// call's completion event or ctx.Done(). This gives library consumers
+// idiomatic cancellable Go APIs without hand writing that dispatch/wait
+// shim, and without the data race a direct C call from an arbitrary
+// goroutine would be.
+type asyncPlugin struct{}
+
+func (asyncPlugin) Name() string { return "wrappers_async_gen.go" }
+
+func (asyncPlugin) Generate(api *API, out *File) error {
+	fmt.Fprintln(out, copyright)
+	fmt.Fprint(out, `
+package event
+
+import (
+	"context"
+	"unsafe"
+
+	"qpid.apache.org/proton/go/internal"
+)
+
+// #include <proton/types.h>
+import "C"
+`)
+	for _, s := range api.Structs {
+		for _, fn := range s.Funcs {
+			if event, ok := asyncCompletions[s.Name+"."+fn.CName]; ok {
+				genAsyncFn(out, s, fn, event)
+			}
+		}
+	}
+	return nil
+}
+
+// asyncFn is the template data for one <Name>Ctx wrapper.
+type asyncFn struct {
+	Struct    string // wrapper struct name, e.g. "Link"
+	Recv      byte   // receiver letter, matches the blocking wrapper's
+	Name      string // Ctx method name, e.g. "FlowCtx"
+	BlockName string // name of the existing blocking method, e.g. "Flow"
+	API       string // C api name, e.g. "link"
+	CName     string // C function name, e.g. "flow"
+	Event     string // EventType name to wait for, without the leading "E"
+	Args      []genArg
+	HasResult bool
+	GoResult  string
+}
+
+func genAsyncFn(out *File, s structAPI, fn funcType, event string) {
+	data := asyncFn{
+		Struct: mixedCase(s.Name), Recv: s.Name[0], Name: fn.GoName + "Ctx", BlockName: fn.GoName,
+		API: s.Name, CName: fn.CName, Event: event, Args: fn.Args,
+		HasResult: fn.Return.Gotype != "",
+		GoResult:  fn.Return.Gotype,
+	}
+	// GoResult == "error" for the pn_error_t*-returning blocking calls
+	// (the other asyncCompletions trigger besides a completion event):
+	// those still capture BlockName's return via result so the real
+	// error reaches the caller instead of being discarded, they just
+	// report it as the method's sole return value rather than wrapping
+	// it in a (T, error) pair.
+	doTemplate(out, data, `
+// {{.Name}} is the cancellable, event-loop-driven equivalent of {{.BlockName}}:
+// it dispatches the call onto the goroutine driving {{printf "%c" .Recv}}'s
+// event loop and waits for E{{.Event}} there, or returns ctx.Err() if ctx is
+// done first.
+func ({{printf "%c" .Recv}} {{.Struct}}) {{.Name}}(ctx context.Context{{range .Args}}, {{.Name}} {{.Gotype}}{{end}}) {{if not .HasResult}}error{{else if eq .GoResult "error"}}error{{else}}({{.GoResult}}, error){{end}} {
+	waiter := internal.AwaitEvent(unsafe.Pointer({{printf "%c" .Recv}}.pn), int(E{{.Event}}))
+	defer waiter.Cancel()
+{{if .HasResult}}	var result {{.GoResult}}
+{{end}}	internal.Inject(unsafe.Pointer({{printf "%c" .Recv}}.pn), func() {
+{{range .Args}}{{if .HasAssign}}		{{.Assign .Name}}
+{{end}}{{end}}		C.pn_{{.API}}_{{.CName}}({{printf "%c" .Recv}}.pn{{range .Args}}, {{.ToC .Name}}{{end}})
+{{if .HasResult}}		result = {{printf "%c" .Recv}}.{{.BlockName}}()
+{{end}}	})
+	select {
+	case <-waiter.Done():
+{{if not .HasResult}}		return nil
+{{else if eq .GoResult "error"}}		return result
+{{else}}		return result, nil
+{{end}}	case <-ctx.Done():
+{{if not .HasResult}}		return ctx.Err()
+{{else if eq .GoResult "error"}}		return ctx.Err()
+{{else}}		var zero {{.GoResult}}
+		return zero, ctx.Err()
+{{end}}	}
+}
+`)
+}
+
+// asyncTestPlugin emits wrappers_async_gen_test.go: a test per <Name>Ctx
+// wrapper on Connection proving the internal.Inject/AwaitEvent dispatch
+// genAsyncFn generates actually honours ctx cancellation -- an already
+// cancelled ctx must win the select over waiting for the completion event.
+// Connection is the only asyncCompletions struct usable here: it needs no
+// setup beyond pn_connection() to construct, where link/session/delivery
+// need a parent connection and a live reactor pumping events to drive the
+// success path, and this tree has no collector/pump machinery to do that.
+type asyncTestPlugin struct{}
+
+func (asyncTestPlugin) Name() string { return "wrappers_async_gen_test.go" }
+
+func (asyncTestPlugin) Generate(api *API, out *File) error {
+	fmt.Fprintln(out, copyright)
+	fmt.Fprint(out, `
+package event
+
+import (
+	"context"
+	"testing"
+)
+
+// #include <proton/connection.h>
+import "C"
+`)
+	for _, s := range api.Structs {
+		if s.Name != "connection" {
+			continue
+		}
+		for _, fn := range s.Funcs {
+			if _, ok := asyncCompletions[s.Name+"."+fn.CName]; ok {
+				genAsyncFnTest(out, fn)
+			}
+		}
+	}
+	return nil
+}
+
+func genAsyncFnTest(out *File, fn funcType) {
+	doTemplate(out, fn.GoName+"Ctx", `
+// Test{{.}}CancelledContext proves {{.}} prefers an already-done ctx over
+// waiting on its completion event, without needing a reactor to ever
+// deliver that event.
+func Test{{.}}CancelledContext(t *testing.T) {
+	c := Connection{pn: C.pn_connection()}
+	defer C.pn_connection_free(c.pn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.{{.}}(ctx); err != ctx.Err() {
+		t.Fatalf("{{.}}(cancelled ctx) = %v, want %v", err, ctx.Err())
+	}
+}
+`)
+}
@@ -0,0 +1,101 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+func init() { RegisterPlugin(wrapperPlugin{}) }
+
+// wrapperPlugin is the built-in plugin that emits the thin blocking Go
+// wrapper API around the C proton structs and enums. It reproduces what
+// genwrap did before the IR/plugin split; it is registered like any other
+// plugin so that out-of-tree plugins (e.g. a mocks or metrics generator)
+// can sit alongside it and consume the same API.
+type wrapperPlugin struct{}
+
+func (wrapperPlugin) Name() string { return "wrappers_gen.go" }
+
+func (wrapperPlugin) Generate(api *API, out *File) error {
+	fmt.Fprintln(out, copyright)
+	fmt.Fprint(out, `
+package event
+
+import (
+	"time"
+  "unsafe"
+  "qpid.apache.org/proton/go/internal"
+)
+
+// #include <proton/types.h>
+// #include <proton/event.h>
+// #include <stdlib.h>
+`)
+	for _, s := range api.Structs {
+		fmt.Fprintf(out, "// #include <proton/%s.h>\n", s.Name)
+	}
+	fmt.Fprintln(out, `import "C"`)
+
+	genEvents(out, api.Events)
+
+	for _, s := range api.Structs {
+		fmt.Fprintf(out, "// Wrappers for declarations in %s.h\n\n", s.Name)
+		for _, e := range s.Enums {
+			genEnum(out, e.Name, e.Values)
+		}
+		genStructFns(out, s)
+	}
+	return nil
+}
+
+// genEvents emits the EventType enum and its String() method.
+func genEvents(out io.Writer, events []eventType) {
+	doTemplate(out, events, `
+type EventType int
+const ({{range .}}
+	 E{{.Name}} EventType = C.{{.Cname}}{{end}}
+)
+`)
+
+	doTemplate(out, events, `
+func (e EventType) String() string {
+	switch e {
+{{range .}}
+	case C.{{.Cname}}: return "{{.Name}}"{{end}}
+	}
+	return "Unknown"
+}
+`)
+}
+
+// genStructFns emits the wrapper struct and a method per function in s.
+func genStructFns(out io.Writer, s structAPI) {
+	fmt.Fprintf(out, "type %s struct{pn *C.pn_%s_t}\n", mixedCase(s.Name), s.Name)
+	fmt.Fprintf(out, "func (%c %s) IsNil() bool { return %c.pn == nil }\n", s.Name[0], mixedCase(s.Name), s.Name[0])
+	for _, fn := range s.Funcs {
+		fmt.Fprintf(out, "func (%c %s) %s", s.Name[0], mixedCase(s.Name), fn.GoName)
+		fmt.Fprintf(out, "(%s) %s { ", goArgs(fn.Args), fn.Return.Gotype)
+		fmt.Fprint(out, cAssigns(fn.Args))
+		fn.Return.printBody(out, fmt.Sprintf("C.pn_%s_%s(%c.pn%s)", s.Name, fn.CName, s.Name[0], cArgs(fn.Args)))
+		fmt.Fprintf(out, "}\n")
+	}
+}
@@ -28,7 +28,6 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"regexp"
 	"strings"
@@ -58,12 +57,19 @@ func doTemplate(out io.Writer, data interface{}, tmpl string) {
 type enumType struct {
 	Name   string
 	Values []string
+	Header string // header file this enum was found in, e.g. "link.h"
+	Line   int    // line of the typedef in Header, for diagnostics
 }
 
-// Find enums in a header file return map of enum name to values.
-func findEnums(header string) (enums []enumType) {
-	for _, enum := range enumDefRe.FindAllStringSubmatch(header, -1) {
-		enums = append(enums, enumType{enum[2], enumValRe.FindAllString(enum[1], -1)})
+// Find enums in a header, tagging each with its source header and line so
+// diagnostics can point back at the declaration.
+func findEnums(headerName, header string) (enums []enumType) {
+	for _, idx := range enumDefRe.FindAllStringSubmatchIndex(header, -1) {
+		values, name := header[idx[2]:idx[3]], header[idx[4]:idx[5]]
+		enums = append(enums, enumType{
+			Name: name, Values: enumValRe.FindAllString(values, -1),
+			Header: headerName + ".h", Line: lineAt(header, idx[0]),
+		})
 	}
 	return enums
 }
@@ -149,44 +155,27 @@ var (
 	skipFnRe    = regexp.MustCompile("attach|context|class|collect|^recv$|^send$|transport")
 )
 
-// Generate event wrappers.
-func event(out io.Writer) {
-	event_h := readHeader("event")
-
-	// Event is implented by hand in wrappers.go
-
-	// Get all the pn_event_type_t enum values
-	var etypes []eventType
-	enums := findEnums(event_h)
-	for _, e := range enums[0].Values {
-		if skipEventRe.FindStringSubmatch(e) == nil {
-			etypes = append(etypes, newEventType(e))
-		}
-	}
-
-	doTemplate(out, etypes, `
-type EventType int
-const ({{range .}}
-	 E{{.Name}} EventType = C.{{.Cname}}{{end}}
+// typeKind tags how a C type converts to and from Go. It replaces what
+// used to be per-instance closure fields on genType, so genType (and the
+// API IR that embeds it) stays plain data -- serializable, e.g. to JSON,
+// instead of only usable in the process that built it.
+type typeKind int
+
+const (
+	kindPlain    typeKind = iota // simple C(v)/Go(v) conversion: int, bool, uint32...
+	kindPointer                  // unsafe.Pointer, cast only
+	kindCString                  // char*/const char*: needs a CString + defer free
+	kindDuration                 // pn_seconds_t -> time.Duration
+	kindError                    // pn_error_t* -> error
+	kindStruct                   // pn_x_t* wrapped in a Go struct, e.g. Link
+	kindEnum                     // pn_x_t value that's really an enum/int, e.g. EventType
 )
-`)
-
-	doTemplate(out, etypes, `
-func (e EventType) String() string {
-	switch e {
-{{range .}}
-	case C.{{.Cname}}: return "{{.Name}}"{{end}}
-	}
-	return "Unknown"
-}
-`)
-}
 
 type genType struct {
 	Ctype, Gotype string
-	ToGo          func(value string) string
-	ToC           func(value string) string
-	Assign        func(value string) string
+	Raw           string // Ctype as written in the header, e.g. "uint32_t *"
+	Kind          typeKind
+	Err           string // set instead of panicking when Raw couldn't be mapped at all
 }
 
 func (g genType) printBody(out io.Writer, value string) {
@@ -197,21 +186,48 @@ func (g genType) printBody(out io.Writer, value string) {
 	}
 }
 
-func (g genType) goLiteral(value string) string {
-	return fmt.Sprintf("%s{%s}", g.Gotype, value)
-}
-
-func (g genType) goConvert(value string) string {
-	switch g.Gotype {
-	case "string":
+// ToGo renders the Go expression that converts a C value into g.Gotype.
+func (g genType) ToGo(value string) string {
+	switch g.Kind {
+	case kindDuration:
+		return fmt.Sprintf("(time.Duration(%s) * time.Second)", value)
+	case kindError:
+		return fmt.Sprintf("internal.PnError(unsafe.Pointer(%s))", value)
+	case kindStruct:
+		return fmt.Sprintf("%s{%s}", g.Gotype, value)
+	case kindCString:
 		return fmt.Sprintf("C.GoString(%s)", value)
-	case "Event":
-		return fmt.Sprintf("makeEvent(%s)", value)
 	default:
 		return fmt.Sprintf("%s(%s)", g.Gotype, value)
 	}
 }
 
+// ToC renders the C expression that converts a Go value of type g.Gotype
+// into g.Ctype.
+func (g genType) ToC(value string) string {
+	switch g.Kind {
+	case kindCString:
+		return value + "C"
+	case kindStruct:
+		return value + ".pn"
+	default:
+		return fmt.Sprintf("%s(%s)", g.Ctype, value)
+	}
+}
+
+// HasAssign reports whether value needs a helper statement (via Assign)
+// before it can be passed to ToC, e.g. to CString a Go string argument.
+func (g genType) HasAssign() bool { return g.Kind == kindCString }
+
+// Assign renders the helper statement ToC's expression for value depends
+// on. Only meaningful when HasAssign is true.
+func (g genType) Assign(value string) string {
+	if g.Kind != kindCString {
+		return ""
+	}
+	return fmt.Sprintf("%sC := C.CString(%s)\n defer C.free(unsafe.Pointer(%sC))\n", value, value, value)
+}
+
 var notStruct = map[string]bool{
 	"EventType":        true,
 	"SndSettleMode":    true,
@@ -223,8 +239,19 @@ var notStruct = map[string]bool{
 	"DistributionMode": true,
 }
 
-func mapType(ctype string) (g genType) {
-	g.Ctype = "C." + strings.Trim(ctype, " \n")
+// tryMapType never panics: an unknown C type is recorded on the returned
+// genType's Err field (and also returned as err, for callers that want to
+// fail fast) instead of crashing generation partway through. parseAPI and
+// splitArgs call this, not mapType, so a bad header produces an IR that
+// Validate can report on rather than a bare stack trace.
+func tryMapType(ctype string) (g genType, err error) {
+	defer func() {
+		if err != nil {
+			g.Err = err.Error()
+		}
+	}()
+	g.Raw = strings.Trim(ctype, " \n")
+	g.Ctype = "C." + g.Raw
 
 	switch g.Ctype {
 	case "C.void":
@@ -236,6 +263,7 @@ func mapType(ctype string) (g genType) {
 	case "C.void *":
 		g.Gotype = "unsafe.Pointer"
 		g.Ctype = "unsafe.Pointer"
+		g.Kind = kindPointer
 	case "C.bool":
 		g.Gotype = "bool"
 	case "C.ssize_t":
@@ -243,52 +271,55 @@ func mapType(ctype string) (g genType) {
 	case "C.uint64_t":
 		g.Gotype = "uint64"
 	case "C.uint32_t":
-		g.Gotype = "uint16"
-	case "C.uint16_t":
 		g.Gotype = "uint32"
+	case "C.uint16_t":
+		g.Gotype = "uint16"
 	case "C.const char *":
 		fallthrough
 	case "C.char *":
 		g.Gotype = "string"
 		g.Ctype = "C.CString"
-		g.ToC = func(v string) string { return fmt.Sprintf("%sC", v) }
-		g.Assign = func(v string) string {
-			return fmt.Sprintf("%sC := C.CString(%s)\n defer C.free(unsafe.Pointer(%sC))\n", v, v, v)
-		}
+		g.Kind = kindCString
 	case "C.pn_seconds_t":
 		g.Gotype = "time.Duration"
-		g.ToGo = func(v string) string { return fmt.Sprintf("(time.Duration(%s) * time.Second)", v) }
+		g.Kind = kindDuration
 	case "C.pn_error_t *":
 		g.Gotype = "error"
-		g.ToGo = func(v string) string { return fmt.Sprintf("internal.PnError(unsafe.Pointer(%s))", v) }
+		g.Kind = kindError
 	default:
 		pnId := regexp.MustCompile(" *pn_([a-z_]+)_t *\\*? *")
 		match := pnId.FindStringSubmatch(g.Ctype)
 		if match == nil {
-			panic(fmt.Errorf("unknown C type %#v", g.Ctype))
+			return g, fmt.Errorf("unknown C type %#v", g.Ctype)
 		}
 		g.Gotype = mixedCase(match[1])
-		if !notStruct[g.Gotype] {
-			g.ToGo = g.goLiteral
-			g.ToC = func(v string) string { return v + ".pn" }
+		if notStruct[g.Gotype] {
+			g.Kind = kindEnum
+		} else {
+			g.Kind = kindStruct
 		}
 	}
-	if g.ToGo == nil {
-		g.ToGo = g.goConvert // Use conversion by default.
-	}
-	if g.ToC == nil {
-		g.ToC = func(v string) string { return fmt.Sprintf("%s(%s)", g.Ctype, v) }
-	}
-	return
+	return g, nil
 }
 
 type genArg struct {
 	Name string
 	genType
+	RenamedFrom string // original C arg name, if Name was changed to dodge a goKeywords collision; "" otherwise
 }
 
 var typeNameRe = regexp.MustCompile("^(.*( |\\*))([^ *]+)$")
 
+// goKeywords are reserved words that can't be used as Go identifiers. C arg
+// names that collide with one of these get an underscore appended.
+var goKeywords = map[string]bool{
+	"break": true, "default": true, "func": true, "interface": true, "select": true,
+	"case": true, "defer": true, "go": true, "map": true, "struct": true,
+	"chan": true, "else": true, "goto": true, "package": true, "switch": true,
+	"const": true, "fallthrough": true, "if": true, "range": true, "type": true,
+	"continue": true, "for": true, "import": true, "return": true, "var": true,
+}
+
 func splitArgs(argstr string) []genArg {
 	argstr = strings.Trim(argstr, " \n")
 	if argstr == "" {
@@ -302,11 +333,17 @@ func splitArgs(argstr string) []genArg {
 			panic(fmt.Errorf("Can't split argument type/name %#v", item))
 		}
 		cType := strings.Trim(typeName[1], " \n")
-		name := strings.Trim(typeName[3], " \n")
-		if name == "type" {
-			name = "type_"
+		orig := strings.Trim(typeName[3], " \n")
+		name := orig
+		if goKeywords[name] {
+			name = name + "_"
 		}
-		args = append(args, genArg{name, mapType(cType)})
+		g, _ := tryMapType(cType)
+		arg := genArg{Name: name, genType: g}
+		if name != orig {
+			arg.RenamedFrom = orig
+		}
+		args = append(args, arg)
 	}
 	return args
 }
@@ -333,7 +370,7 @@ func cArgs(args []genArg) string {
 func cAssigns(args []genArg) string {
 	l := "\n"
 	for _, arg := range args {
-		if arg.Assign != nil {
+		if arg.HasAssign() {
 			l += fmt.Sprintf("%s\n", arg.Assign(arg.Name))
 		}
 	}
@@ -354,74 +391,38 @@ func goFnName(api, fname string) string {
 	}
 }
 
-func apiWrapFns(api, header string, out io.Writer) {
-	fmt.Fprintf(out, "type %s struct{pn *C.pn_%s_t}\n", mixedCase(api), api)
-	fmt.Fprintf(out, "func (%c %s) IsNil() bool { return %c.pn == nil }\n", api[0], mixedCase(api), api[0])
-	fn := regexp.MustCompile(fmt.Sprintf(`PN_EXTERN ([a-z0-9_ ]+ *\*?) *pn_%s_([a-z_]+)\(pn_%s_t *\*[a-z_]+ *,? *([^)]*)\)`, api, api))
-	for _, m := range fn.FindAllStringSubmatch(header, -1) {
-		rtype, fname, argstr := mapType(m[1]), m[2], m[3]
-		gname := goFnName(api, fname)
-		if gname == "" { // Skip
-			continue
-		}
-		args := splitArgs(argstr)
-		fmt.Fprintf(out, "func (%c %s) %s", api[0], mixedCase(api), gname)
-		fmt.Fprintf(out, "(%s) %s { ", goArgs(args), rtype.Gotype)
-		fmt.Fprint(out, cAssigns(args))
-		rtype.printBody(out, fmt.Sprintf("C.pn_%s_%s(%c.pn%s)", api, fname, api[0], cArgs(args)))
-		fmt.Fprintf(out, "}\n")
-	}
-}
-
 var includeProton = flag.String("include", "", "path to proton include files, including /proton")
+var fix = flag.Bool("fix", false, "rewrite fixable type-map problems instead of failing")
+
+// apis lists the pn_<name>_t structs genwrap generates wrappers for.
+var apis = []string{"session", "link", "delivery", "disposition", "condition", "terminus", "connection"}
 
 func main() {
 	flag.Parse()
-	outpath := "wrappers_gen.go"
-	out, err := os.Create(outpath)
-	panicIf(err)
-	defer out.Close()
-
-	apis := []string{"session", "link", "delivery", "disposition", "condition", "terminus", "connection"}
-	fmt.Fprintln(out, copyright)
-	fmt.Fprint(out, `
-package event
-
-import (
-	"time"
-  "unsafe"
-  "qpid.apache.org/proton/go/internal"
-)
+	api := parseAPI(apis)
 
-// #include <proton/types.h>
-// #include <proton/event.h>
-// #include <stdlib.h>
-`)
-	for _, api := range apis {
-		fmt.Fprintf(out, "// #include <proton/%s.h>\n", api)
+	diags := Validate(api)
+	hasFatal := false
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d)
+		hasFatal = hasFatal || d.Severity == fatal
 	}
-	fmt.Fprintln(out, `import "C"`)
-
-	event(out)
-
-	for _, api := range apis {
-		fmt.Fprintf(out, "// Wrappers for declarations in %s.h\n\n", api)
-		header := readHeader(api)
-		enums := findEnums(header)
-		for _, e := range enums {
-			genEnum(out, e.Name, e.Values)
+	if hasFatal {
+		if !*fix {
+			fmt.Fprintln(os.Stderr, "genwrap: validation found errors, re-run with -fix to apply suggested fixes")
+			os.Exit(1)
+		}
+		if n := fixWidths(api); n > 0 {
+			fmt.Fprintf(os.Stderr, "genwrap: -fix corrected %d type mapping(s)\n", n)
+		}
+		for _, d := range Validate(api) {
+			if d.Severity == fatal {
+				fmt.Fprintln(os.Stderr, d)
+				fmt.Fprintln(os.Stderr, "genwrap: -fix could not resolve every error")
+				os.Exit(1)
+			}
 		}
-		apiWrapFns(api, header, out)
 	}
-	out.Close()
 
-	// Run gofmt.
-	cmd := exec.Command("gofmt", "-w", outpath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "gofmt: %s", err)
-		os.Exit(1)
-	}
+	panicIf(generate(api))
 }
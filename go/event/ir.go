@@ -0,0 +1,112 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// This file builds the intermediate representation (IR) of the proton C
+// API by parsing the header files. Plugins consume the IR to emit Go code;
+// they never parse headers themselves.
+
+// API is the complete, serializable representation of the parsed proton
+// headers. It is built once by parseAPI and then handed to every
+// registered Plugin.
+type API struct {
+	Events  []eventType
+	Structs []structAPI
+}
+
+// structAPI is the parsed pn_<name>_t API: the enums it declares and the
+// functions hung off its C struct.
+type structAPI struct {
+	Name    string // e.g. "session", "link"
+	Header  string // raw text of <name>.h, kept for plugins that need it
+	Enums   []enumType
+	Funcs   []funcType
+	Skipped []skippedFunc // functions dropped by skipFnRe, for coverage audits
+}
+
+// funcType is one pn_<api>_<fn> declaration.
+type funcType struct {
+	CName  string // bare C function name, e.g. "get_drain"
+	GoName string // method name on the wrapper struct
+	Args   []genArg
+	Return genType
+	Line   int    // line of the declaration in Header, for diagnostics
+	Decl   string // raw matched declaration text, for diagnostics
+}
+
+// skippedFunc records a pn_<api>_<fn> declaration that goFnName chose not
+// to wrap, so Validate can warn about it and users can audit coverage.
+type skippedFunc struct {
+	CName string
+	Line  int
+}
+
+// lineAt returns the 1-based line number of byte offset pos in text.
+func lineAt(text string, pos int) int {
+	return strings.Count(text[:pos], "\n") + 1
+}
+
+// parseAPI reads the headers for event and every api in apis and returns
+// the parsed IR. It does no code generation.
+func parseAPI(apis []string) *API {
+	api := &API{}
+
+	event_h := readHeader("event")
+	enums := findEnums("event", event_h)
+	for _, e := range enums[0].Values {
+		if skipEventRe.FindStringSubmatch(e) == nil {
+			api.Events = append(api.Events, newEventType(e))
+		}
+	}
+
+	for _, name := range apis {
+		header := readHeader(name)
+		s := structAPI{Name: name, Header: header, Enums: findEnums(name, header)}
+		fn := regexp.MustCompile(fmt.Sprintf(`PN_EXTERN ([a-z0-9_ ]+ *\*?) *pn_%s_([a-z_]+)\(pn_%s_t *\*[a-z_]+ *,? *([^)]*)\)`, name, name))
+		for _, idx := range fn.FindAllStringSubmatchIndex(header, -1) {
+			m := make([]string, len(idx)/2)
+			for i := range m {
+				if idx[2*i] >= 0 {
+					m[i] = header[idx[2*i]:idx[2*i+1]]
+				}
+			}
+			line := lineAt(header, idx[0])
+			rtype, _ := tryMapType(m[1])
+			fname, argstr := m[2], m[3]
+			gname := goFnName(name, fname)
+			if gname == "" {
+				s.Skipped = append(s.Skipped, skippedFunc{CName: fname, Line: line})
+				continue
+			}
+			s.Funcs = append(s.Funcs, funcType{
+				CName: fname, GoName: gname, Args: splitArgs(argstr), Return: rtype,
+				Line: line, Decl: m[0],
+			})
+		}
+		api.Structs = append(api.Structs, s)
+	}
+	return api
+}
@@ -0,0 +1,81 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+)
+
+// File is an output file a Plugin writes generated code into. Generate may
+// be called multiple times for the same File by different plugins; their
+// output is concatenated in registration order.
+type File struct {
+	Name string // output path, e.g. "wrappers_gen.go"
+	buf  bytes.Buffer
+}
+
+func (f *File) Write(p []byte) (int, error) { return f.buf.Write(p) }
+
+// Plugin emits Go source into a File from the parsed API IR. Plugins never
+// parse headers themselves; all the data they need comes from the API.
+type Plugin interface {
+	// Name identifies the plugin, e.g. for -fix reports or logging.
+	Name() string
+	// Generate writes code for api into out.
+	Generate(api *API, out *File) error
+}
+
+var plugins []Plugin
+
+// RegisterPlugin adds p to the set of plugins run by generate. Plugins
+// register themselves from an init() function, the way database/sql
+// drivers register themselves.
+func RegisterPlugin(p Plugin) {
+	plugins = append(plugins, p)
+}
+
+// generate runs every registered plugin against api, one output file per
+// plugin, and gofmt's the result.
+func generate(api *API) error {
+	for _, p := range plugins {
+		out := &File{Name: p.Name()}
+		if err := p.Generate(api, out); err != nil {
+			return fmt.Errorf("%s: %v", p.Name(), err)
+		}
+		if err := writeFormatted(out); err != nil {
+			return fmt.Errorf("%s: %v", p.Name(), err)
+		}
+	}
+	return nil
+}
+
+func writeFormatted(f *File) error {
+	if err := ioutil.WriteFile(f.Name, f.buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	cmd := exec.Command("gofmt", "-w", f.Name)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+	return cmd.Run()
+}
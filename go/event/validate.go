@@ -0,0 +1,184 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// severity distinguishes diagnostics that block generation from ones that
+// are only worth a user's attention.
+type severity int
+
+const (
+	warning severity = iota
+	fatal
+)
+
+func (s severity) String() string {
+	if s == fatal {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic is one problem found by Validate. It always carries enough
+// location context -- the header file, line number and raw C declaration
+// -- to let a user go straight to the offending line.
+type Diagnostic struct {
+	Severity severity
+	Header   string
+	Line     int
+	Decl     string
+	Message  string
+	Fix      string // suggested fix, "" if there isn't an automatic one
+}
+
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("%s:%d: %s: %s: %s", d.Header, d.Line, d.Severity, d.Decl, d.Message)
+	if d.Fix != "" {
+		s += fmt.Sprintf(" (fix: %s)", d.Fix)
+	}
+	return s
+}
+
+// Validate inspects the parsed API for problems that would otherwise be
+// caught only by a panic mid-generation (an unknown C type) or silently
+// mis-translated (a fixed-width integer mapped to the wrong Go width). It
+// also reports, as warnings, functions skipFnRe dropped, enum values that
+// collide once mixedCase'd, and args splitArgs renamed to dodge a Go
+// keyword collision, so coverage gaps are visible instead of silent.
+func Validate(api *API) (diags []Diagnostic) {
+	for _, s := range api.Structs {
+		diags = append(diags, validateEnums(s)...)
+		diags = append(diags, validateFuncs(s)...)
+		diags = append(diags, validateSkipped(s)...)
+	}
+	return diags
+}
+
+var widthRe = regexp.MustCompile(`u?int(\d+)`)
+
+// bitWidth extracts the bit width from a C or Go fixed-width integer type
+// name, e.g. "uint32_t" or "uint32" -> 32.
+func bitWidth(name string) (int, bool) {
+	m := widthRe.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
+
+// checkType reports a Diagnostic for a genType that tryMapType couldn't map
+// at all (its Err is already stashed on it by the parser), or for a
+// fixed-width C integer mapped to a Go integer of a different width -- the
+// bug class that originally swapped uint16_t and uint32_t. It never calls
+// tryMapType itself: g.Err is read from the IR that parseAPI/splitArgs
+// already built, so an unknown type surfaces here instead of panicking
+// during parsing.
+func checkType(header string, line int, decl string, g genType) []Diagnostic {
+	if g.Err != "" {
+		return []Diagnostic{{Severity: fatal, Header: header, Line: line, Decl: decl, Message: g.Err}}
+	}
+	cWidth, cOk := bitWidth(g.Raw)
+	goWidth, goOk := bitWidth(g.Gotype)
+	if cOk && goOk && cWidth != goWidth {
+		return []Diagnostic{{
+			Severity: fatal, Header: header, Line: line, Decl: decl,
+			Message: fmt.Sprintf("%s (%d bits) maps to Go type %s (%d bits)", g.Raw, cWidth, g.Gotype, goWidth),
+			Fix:     fmt.Sprintf("map %s to a %d-bit Go type", g.Raw, cWidth),
+		}}
+	}
+	return nil
+}
+
+func validateFuncs(s structAPI) (diags []Diagnostic) {
+	header := s.Name + ".h"
+	for _, fn := range s.Funcs {
+		diags = append(diags, checkType(header, fn.Line, fn.Decl, fn.Return)...)
+		for _, arg := range fn.Args {
+			diags = append(diags, checkType(header, fn.Line, fn.Decl, arg.genType)...)
+			if arg.RenamedFrom != "" {
+				diags = append(diags, Diagnostic{
+					Severity: warning, Header: header, Line: fn.Line, Decl: fn.Decl,
+					Message: fmt.Sprintf("arg %s renamed to %s to avoid colliding with a Go keyword", arg.RenamedFrom, arg.Name),
+				})
+			}
+		}
+	}
+	return diags
+}
+
+func validateEnums(s structAPI) (diags []Diagnostic) {
+	for _, e := range s.Enums {
+		seen := map[string]string{}
+		for _, v := range e.Values {
+			name := mixedCase(v)
+			if other, ok := seen[name]; ok {
+				diags = append(diags, Diagnostic{
+					Severity: warning, Header: e.Header, Line: e.Line, Decl: e.Name,
+					Message: fmt.Sprintf("enum values %s and %s both mixedCase to %s", other, v, name),
+				})
+			}
+			seen[name] = v
+		}
+	}
+	return diags
+}
+
+// fixWidths rewrites fixed-width integer Gotypes in api to match their C
+// declaration's bit width, the class of problem checkType reports. It
+// returns how many mappings it changed, for a -fix summary.
+func fixWidths(api *API) int {
+	n := 0
+	fix := func(g *genType) {
+		cWidth, cOk := bitWidth(g.Raw)
+		goWidth, goOk := bitWidth(g.Gotype)
+		if cOk && goOk && cWidth != goWidth {
+			g.Gotype = strings.Replace(g.Gotype, strconv.Itoa(goWidth), strconv.Itoa(cWidth), 1)
+			n++
+		}
+	}
+	for si := range api.Structs {
+		for fi := range api.Structs[si].Funcs {
+			fn := &api.Structs[si].Funcs[fi]
+			fix(&fn.Return)
+			for ai := range fn.Args {
+				fix(&fn.Args[ai].genType)
+			}
+		}
+	}
+	return n
+}
+
+func validateSkipped(s structAPI) (diags []Diagnostic) {
+	header := s.Name + ".h"
+	for _, skip := range s.Skipped {
+		diags = append(diags, Diagnostic{
+			Severity: warning, Header: header, Line: skip.Line, Decl: "pn_" + s.Name + "_" + skip.CName,
+			Message: "function skipped by skipFnRe, no Go wrapper generated",
+		})
+	}
+	return diags
+}